@@ -0,0 +1,125 @@
+package controller
+
+import "sync"
+
+// Chunk size classes for the pooled buffers backing dataBuffer, mirroring
+// the approach used by golang.org/x/net/http2's dataBuffer: small chunks
+// while the buffer is small, capped at 64KB once it grows, so a single
+// large burst doesn't force many small chunks.
+const (
+	chunkSize1KB  = 1 << 10
+	chunkSize4KB  = 4 << 10
+	chunkSize16KB = 16 << 10
+	chunkSize64KB = 64 << 10
+)
+
+var (
+	chunkPool1KB  = sync.Pool{New: func() interface{} { return make([]byte, chunkSize1KB) }}
+	chunkPool4KB  = sync.Pool{New: func() interface{} { return make([]byte, chunkSize4KB) }}
+	chunkPool16KB = sync.Pool{New: func() interface{} { return make([]byte, chunkSize16KB) }}
+	chunkPool64KB = sync.Pool{New: func() interface{} { return make([]byte, chunkSize64KB) }}
+)
+
+// nextChunkSize picks the size class for the next chunk to allocate,
+// based on the number of bytes already buffered: small when the buffer is
+// small, capped at 64KB once it's large.
+func nextChunkSize(bufSize int) int {
+	switch {
+	case bufSize < chunkSize1KB:
+		return chunkSize1KB
+	case bufSize < chunkSize4KB:
+		return chunkSize4KB
+	case bufSize < chunkSize16KB:
+		return chunkSize16KB
+	default:
+		return chunkSize64KB
+	}
+}
+
+// getChunk returns a full-length chunk of the given size class from its
+// pool, allocating one if the pool is empty.
+func getChunk(size int) []byte {
+	switch size {
+	case chunkSize1KB:
+		return chunkPool1KB.Get().([]byte)
+	case chunkSize4KB:
+		return chunkPool4KB.Get().([]byte)
+	case chunkSize16KB:
+		return chunkPool16KB.Get().([]byte)
+	default:
+		return chunkPool64KB.Get().([]byte)
+	}
+}
+
+// putChunk returns a fully-drained chunk to its size-class pool.
+func putChunk(buf []byte) {
+	switch len(buf) {
+	case chunkSize1KB:
+		chunkPool1KB.Put(buf) //nolint:staticcheck
+	case chunkSize4KB:
+		chunkPool4KB.Put(buf) //nolint:staticcheck
+	case chunkSize16KB:
+		chunkPool16KB.Put(buf) //nolint:staticcheck
+	case chunkSize64KB:
+		chunkPool64KB.Put(buf) //nolint:staticcheck
+	}
+}
+
+// dataBuffer is a growable byte queue backed by a sequence of pooled,
+// fixed-size chunks rather than a single contiguous slice. Every chunk
+// except the last is always completely full: r is the read offset into
+// chunks[0], w is the write offset into the last chunk. This bounds
+// worst-case fragmentation to one chunk and avoids the memcpy-the-whole-
+// window cost of doubling a single backing slice.
+type dataBuffer struct {
+	chunks [][]byte
+	r, w   int
+	size   int
+}
+
+// lastChunk returns the chunk currently being written to.
+func (b *dataBuffer) lastChunk() []byte {
+	return b.chunks[len(b.chunks)-1]
+}
+
+// firstChunkUnread returns the unread portion of the chunk currently
+// being read from.
+func (b *dataBuffer) firstChunkUnread() []byte {
+	if len(b.chunks) == 1 {
+		return b.chunks[0][b.r:b.w]
+	}
+	return b.chunks[0][b.r:]
+}
+
+// write appends p to the buffer, allocating additional pooled chunks as
+// needed; p may span any number of chunk boundaries.
+func (b *dataBuffer) write(p []byte) {
+	for len(p) > 0 {
+		if len(b.chunks) == 0 || b.w == len(b.lastChunk()) {
+			b.chunks = append(b.chunks, getChunk(nextChunkSize(b.size)))
+			b.w = 0
+		}
+		n := copy(b.lastChunk()[b.w:], p)
+		p = p[n:]
+		b.w += n
+		b.size += n
+	}
+}
+
+// read copies exactly len(dst) bytes from the head of the buffer into
+// dst, returning fully-drained chunks to their size-class pool. The
+// caller must ensure the buffer holds at least len(dst) bytes.
+func (b *dataBuffer) read(dst []byte) {
+	for len(dst) > 0 {
+		n := copy(dst, b.firstChunkUnread())
+		dst = dst[n:]
+		b.r += n
+		b.size -= n
+		if b.r == len(b.chunks[0]) {
+			putChunk(b.chunks[0])
+			b.chunks[0] = nil
+			b.chunks = b.chunks[1:]
+			b.r = 0
+		}
+	}
+}
@@ -1,211 +1,303 @@
 package controller
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"sync"
+	"time"
 )
 
 // Buffer holds incoming DL packets during the UE Idle state
 type Buffer struct {
 	mutex sync.Mutex
 
-	// Using a circular buffer for packets. If head <= tail, then the useful
-	// data is in the interval [head, tail[. If tail < head, then
-	// the useful data is the union of [head, len[ and [0, tail[.
-	// In order to avoid ambiguity when head = tail, we always leave
-	// an unused byte in the buffer.
-	// Each packet prepend a 2-byte header to indicate its length
-	data       []byte
-	head, tail int
-	count      int
+	// buf holds the queued bytes. Each packet prepends a 2-byte length
+	// header and appends a metadataSize-byte metadata trailer; a single
+	// packet may span multiple chunks of buf.
+	buf   dataBuffer
+	count int
+
+	notify chan struct{}
+	closed bool
+
+	limitCount int
+	limitSize  int
+
+	readDeadline time.Time
+
+	policy spillPolicy
+	spill  *spillState
 }
 
-const (
-	minSize    = 2048
-	cutoffSize = 128 * 1024
-)
+// Metadata carries out-of-band information alongside a packet, such as the
+// time it was enqueued. It can be used by callers to implement jitter
+// buffers or age-based eviction on top of Buffer.
+type Metadata struct {
+	Timestamp time.Time
+}
+
+// metadataSize is the wire size of a Metadata trailer: an 8-byte
+// unix-nano timestamp followed by a 2-byte flags field.
+const metadataSize = 8 + 2
+
+// flagHasTimestamp, set in a metadata trailer's flags field, marks that
+// the trailer's timestamp bytes hold a real Metadata.Timestamp rather
+// than the zero time.
+const flagHasTimestamp = 1 << 0
+
+// decodeMetadataTrailer decodes a metadataSize-byte wire trailer written
+// by WriteWithMetadata back into a Metadata.
+func decodeMetadataTrailer(trailer [metadataSize]byte) Metadata {
+	flags := uint16(trailer[8])<<8 | uint16(trailer[9])
+	if flags&flagHasTimestamp == 0 {
+		return Metadata{}
+	}
+
+	var ts uint64
+	for i := 0; i < 8; i++ {
+		ts = ts<<8 | uint64(trailer[i])
+	}
+	return Metadata{Timestamp: time.Unix(0, int64(ts))}
+}
 
 var (
 	// ErrPacketTooBig is returned when the incoming packet is larger than 65536 bytes.
 	ErrPacketTooBig = errors.New("packet too big")
+
+	// ErrFull is returned by Write when LimitCount or LimitSize would be exceeded.
+	ErrFull = errors.New("buffer is full")
+
+	// ErrBufferClosed is returned by Write once the buffer has been closed.
+	ErrBufferClosed = errors.New("buffer is closed")
 )
 
 // NewBuffer creates a new packet buffer.
 func NewBuffer() *Buffer {
-	return &Buffer{}
+	return &Buffer{
+		notify: make(chan struct{}),
+	}
 }
 
-// available returns true if the buffer is large enough to fit a packet
-// of the given size, taking 2-byte length header overhead into account.
-func (b *Buffer) available(size int) bool {
-	available := b.head - b.tail
-	if available <= 0 {
-		available += len(b.data)
-	}
-	// we interpret head=tail as empty, so always keep a byte free
-	if size+2+1 > available {
-		return false
-	}
+// wake unblocks every reader currently waiting in Read by closing the
+// notify channel, then replaces it so that future waiters block again.
+func (b *Buffer) wake() {
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
 
-	return true
+// SetLimitCount sets the maximum number of packets the buffer will hold.
+// Write returns ErrFull once the limit would be exceeded. A limit of 0
+// (the default) means unlimited.
+func (b *Buffer) SetLimitCount(limit int) {
+	b.mutex.Lock()
+	b.limitCount = limit
+	b.mutex.Unlock()
 }
 
-// grow increases the size of the buffer.  If it returns nil, then the
-// buffer has been grown. It returns ErrFull if hits a limit.
-func (b *Buffer) grow() error {
-	var newsize int
-	if len(b.data) < cutoffSize {
-		newsize = 2 * len(b.data)
-	} else {
-		newsize = 5 * len(b.data) / 4
-	}
-	if newsize < minSize {
-		newsize = minSize
-	}
+// SetLimitSize sets the maximum total byte size, including the per-packet
+// header and metadata trailer, the buffer will hold. Write returns
+// ErrFull once the limit would be exceeded. A limit of 0 (the default)
+// means unlimited.
+func (b *Buffer) SetLimitSize(limit int) {
+	b.mutex.Lock()
+	b.limitSize = limit
+	b.mutex.Unlock()
+}
+
+// SetReadDeadline sets the deadline for future calls to Read. A zero
+// value removes the deadline, causing Read to block indefinitely until a
+// packet arrives or the buffer is closed.
+func (b *Buffer) SetReadDeadline(t time.Time) {
+	b.mutex.Lock()
+	b.readDeadline = t
+	b.mutex.Unlock()
+}
+
+// Close closes the buffer, unblocking all pending and future readers with
+// io.EOF once the buffer has drained. Any packets already spilled to disk
+// by a spill policy set with SetSpillPolicy remain readable after Close;
+// the spill file itself is only cleaned up once they have all been read.
+// It is safe to call Close more than once.
+func (b *Buffer) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
 
-	newdata := make([]byte, newsize)
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	b.wake()
 
-	var n int
-	if b.head <= b.tail {
-		// data was contiguous
-		n = copy(newdata, b.data[b.head:b.tail])
-	} else {
-		// data was noncontiguous
-		n = copy(newdata, b.data[b.head:])
-		n += copy(newdata[n:], b.data[:b.tail])
+	if b.spill != nil && b.spill.packets == 0 {
+		b.closeSpill()
 	}
-	b.head = 0
-	b.tail = n
-	b.data = newdata
 
 	return nil
 }
 
 // Write appends a copy of the packet data to the buffer.
-// Returns ErrFull if the buffer is full
+// Returns ErrFull if LimitCount or LimitSize is exceeded
 // Returns ErrPacketTooBig if the packet size exceeds 65536 bytes
+// Returns ErrBufferClosed if the buffer has been closed
 func (b *Buffer) Write(packet []byte) (int, error) {
+	return b.WriteWithMetadata(packet, Metadata{})
+}
+
+// WriteWithMetadata appends a copy of the packet data to the buffer along
+// with its metadata, which ReadWithMetadata later returns alongside the
+// packet. Errors are identical to Write.
+func (b *Buffer) WriteWithMetadata(packet []byte, md Metadata) (int, error) {
 	if len(packet) >= 0x10000 {
 		return 0, ErrPacketTooBig
 	}
 
 	b.mutex.Lock()
 
-	// grow the buffer until the packet fits
-	for !b.available(len(packet)) {
-		err := b.grow()
-		if err != nil {
-			b.mutex.Unlock()
-			return 0, err
-		}
+	if b.closed {
+		b.mutex.Unlock()
+		return 0, ErrBufferClosed
 	}
 
-	// store the length of the packet
-	b.data[b.tail] = uint8(len(packet) >> 8)
-	b.tail++
-	if b.tail >= len(b.data) {
-		b.tail = 0
+	if b.limitCount > 0 && b.totalCountLocked()+1 > b.limitCount {
+		b.mutex.Unlock()
+		return 0, ErrFull
 	}
-	b.data[b.tail] = uint8(len(packet))
-	b.tail++
-	if b.tail >= len(b.data) {
-		b.tail = 0
+	if b.limitSize > 0 && b.totalSizeLocked()+2+metadataSize+len(packet) > b.limitSize {
+		b.mutex.Unlock()
+		return 0, ErrFull
 	}
 
+	// store the length of the packet
+	var header [2]byte
+	header[0] = uint8(len(packet) >> 8)
+	header[1] = uint8(len(packet))
+	b.buf.write(header[:])
+
 	// store the packet
-	n := copy(b.data[b.tail:], packet)
-	b.tail += n
-	if b.tail >= len(b.data) {
-		// we reached the end, wrap around
-		m := copy(b.data, packet[n:])
-		b.tail = m
+	b.buf.write(packet)
+
+	// store the metadata trailer: 8-byte unix-nano timestamp, 2-byte
+	// flags field. flagHasTimestamp distinguishes an explicitly zero
+	// Metadata.Timestamp (flag clear) from the Unix epoch (flag set),
+	// so that the zero Metadata written by Write round-trips through
+	// ReadWithMetadata as the zero time rather than 1970-01-01.
+	var trailer [metadataSize]byte
+	var flags uint16
+	if !md.Timestamp.IsZero() {
+		ts := uint64(md.Timestamp.UnixNano())
+		for i := 0; i < 8; i++ {
+			trailer[i] = uint8(ts >> (56 - 8*i))
+		}
+		flags |= flagHasTimestamp
 	}
+	trailer[8] = uint8(flags >> 8)
+	trailer[9] = uint8(flags)
+	b.buf.write(trailer[:])
+
+	wasEmpty := b.count == 0
 	b.count++
+	if wasEmpty {
+		b.wake()
+	}
+	b.spillOldestLocked()
 	b.mutex.Unlock()
 
 	return len(packet), nil
 }
 
 // Read populates the given byte slice, returning the number of bytes read.
-// If return 0, the buffer is empty
+// If the buffer is empty, Read blocks until a packet is written, the
+// buffer is closed (returning io.EOF), or the read deadline set by
+// SetReadDeadline expires (returning context.DeadlineExceeded).
 // Returns io.ErrShortBuffer is the given packet is too small to copy
 func (b *Buffer) Read(packet []byte) (n int, err error) {
-	b.mutex.Lock()
+	n, _, err = b.ReadWithMetadata(packet)
+	return n, err
+}
 
-	if b.head != b.tail {
-		// decode the packet size
-		n1 := b.data[b.head]
-		b.head++
-		if b.head >= len(b.data) {
-			b.head = 0
-		}
-		n2 := b.data[b.head]
-		b.head++
-		if b.head >= len(b.data) {
-			b.head = 0
+// ReadWithMetadata behaves like Read, but additionally returns the
+// Metadata stored alongside the packet by WriteWithMetadata (or the zero
+// Metadata if the packet was stored with Write).
+func (b *Buffer) ReadWithMetadata(packet []byte) (n int, md Metadata, err error) {
+	for {
+		b.mutex.Lock()
+
+		// Spilled packets are always the oldest ones in the buffer, so
+		// they must be drained before anything still held in memory.
+		if b.spill != nil && b.spill.packets > 0 {
+			n, md, err = b.readSpilledLocked(packet)
+			b.mutex.Unlock()
+			return n, md, err
 		}
-		count := int((uint16(n1) << 8) | uint16(n2))
 
-		copied := count
-		// check if the packet is large enough to hold read data
-		if len(packet) < copied {
-			errMsg := fmt.Sprintf("short buffer, at least %d is needed", count)
-			return 0, errors.New(errMsg)
-		}
+		if b.buf.size > 0 {
+			// decode the packet size
+			var header [2]byte
+			b.buf.read(header[:])
+			count := int((uint16(header[0]) << 8) | uint16(header[1]))
 
-		// copy the data
-		if b.head+copied < len(b.data) {
-			copy(packet, b.data[b.head:b.head+copied])
-		} else {
-			k := copy(packet, b.data[b.head:])
-			copy(packet[k:], b.data[:copied-k])
-		}
+			// check if the packet is large enough to hold read data
+			if len(packet) < count {
+				b.mutex.Unlock()
+				errMsg := fmt.Sprintf("short buffer, at least %d is needed", count)
+				return 0, Metadata{}, errors.New(errMsg)
+			}
 
-		// advance head
-		b.head += count
-		if b.head >= len(b.data) {
-			b.head -= len(b.data)
-		}
+			// copy the packet data
+			b.buf.read(packet[:count])
+
+			// decode the metadata trailer
+			var trailer [metadataSize]byte
+			b.buf.read(trailer[:])
+			md = decodeMetadataTrailer(trailer)
+
+			b.count--
 
-		if b.head == b.tail {
-			// the buffer is empty, reset to beginning
-			// in order to improve cache locality.
-			b.head = 0
-			b.tail = 0
+			b.mutex.Unlock()
+
+			return count, md, nil
 		}
 
-		b.count--
+		if b.closed {
+			b.mutex.Unlock()
+			return 0, Metadata{}, io.EOF
+		}
 
+		notify := b.notify
+		deadline := b.readDeadline
 		b.mutex.Unlock()
 
-		return copied, nil
-	}
+		if deadline.IsZero() {
+			<-notify
+			continue
+		}
 
-	b.mutex.Unlock()
-	return 0, nil
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-notify:
+			timer.Stop()
+		case <-timer.C:
+			return 0, Metadata{}, context.DeadlineExceeded
+		}
+	}
 }
 
-// Count returns the number of packets in the buffer.
+// Count returns the number of packets in the buffer, including any
+// currently spilled to disk by a spill policy set with SetSpillPolicy.
 func (b *Buffer) Count() int {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
-	return b.count
+	return b.totalCountLocked()
 }
 
 // Size returns the total byte size of packets in the buffer, including
-// a small amount of extra length header.
+// the length header and metadata trailer of each packet, and any packets
+// currently spilled to disk by a spill policy set with SetSpillPolicy.
 func (b *Buffer) Size() int {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
-	return b.size()
-}
-
-func (b *Buffer) size() int {
-	size := b.tail - b.head
-	if size < 0 {
-		size += len(b.data)
-	}
-	return size
+	return b.totalSizeLocked()
 }
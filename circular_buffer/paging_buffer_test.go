@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuffer(t *testing.T) {
+	assert := assert.New(t)
+
+	buffer := NewBuffer()
+	packet := make([]byte, 4)
+
+	// Write once
+	n, err := buffer.Write([]byte{0, 1})
+	assert.NoError(err)
+	assert.Equal(2, n)
+
+	// Read once
+	n, err = buffer.Read(packet)
+	assert.NoError(err)
+	assert.Equal(2, n)
+	assert.Equal([]byte{0, 1}, packet[:n])
+
+	// Write twice
+	n, err = buffer.Write([]byte{2, 3, 4})
+	assert.NoError(err)
+	assert.Equal(3, n)
+
+	n, err = buffer.Write([]byte{5, 6, 7})
+	assert.NoError(err)
+	assert.Equal(3, n)
+
+	// Check the buffer count
+	count := buffer.Count()
+	assert.Equal(2, count)
+
+	// Check the buffer size: two packets, each a 2-byte length header +
+	// 3-byte payload + metadataSize-byte trailer
+	size := buffer.Size()
+	assert.Equal(2*(2+3+metadataSize), size)
+
+	// Read twice
+	n, err = buffer.Read(packet)
+	assert.NoError(err)
+	assert.Equal(3, n)
+	assert.Equal([]byte{2, 3, 4}, packet[:n])
+
+	n, err = buffer.Read(packet)
+	assert.NoError(err)
+	assert.Equal(3, n)
+	assert.Equal([]byte{5, 6, 7}, packet[:n])
+
+	// Test Read an empty buffer: with a read deadline set, Read returns
+	// context.DeadlineExceeded once it expires; after Close, it returns io.EOF.
+	buffer.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	n, err = buffer.Read(packet)
+	assert.Equal(context.DeadlineExceeded, err)
+	assert.Equal(0, n)
+
+	err = buffer.Close()
+	assert.NoError(err)
+
+	n, err = buffer.Read(packet)
+	assert.Equal(io.EOF, err)
+	assert.Equal(0, n)
+}
+
+func TestBufferMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	buffer := NewBuffer()
+	packet := make([]byte, 4)
+
+	ts := time.Now()
+	n, err := buffer.WriteWithMetadata([]byte{1, 2, 3}, Metadata{Timestamp: ts})
+	assert.NoError(err)
+	assert.Equal(3, n)
+
+	// ReadWithMetadata returns the packet together with its timestamp.
+	n, md, err := buffer.ReadWithMetadata(packet)
+	assert.NoError(err)
+	assert.Equal(3, n)
+	assert.Equal([]byte{1, 2, 3}, packet[:n])
+	assert.True(ts.Equal(md.Timestamp))
+
+	// Packets written with Write carry the zero Metadata.
+	_, err = buffer.Write([]byte{4, 5})
+	assert.NoError(err)
+	_, md, err = buffer.ReadWithMetadata(packet)
+	assert.NoError(err)
+	assert.True(md.Timestamp.IsZero())
+}
+
+// TestBufferChunkSpanning exercises a packet whose payload alone is
+// larger than a single dataBuffer chunk, so both Write and Read must walk
+// it across multiple chunks.
+func TestBufferChunkSpanning(t *testing.T) {
+	assert := assert.New(t)
+
+	buffer := NewBuffer()
+	payload := make([]byte, 3*chunkSize1KB+17)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	n, err := buffer.Write(payload)
+	assert.NoError(err)
+	assert.Equal(len(payload), n)
+
+	got := make([]byte, len(payload))
+	n, err = buffer.Read(got)
+	assert.NoError(err)
+	assert.Equal(len(payload), n)
+	assert.Equal(payload, got)
+}
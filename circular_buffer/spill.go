@@ -0,0 +1,248 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// spillPolicy configures when Write offloads the oldest queued packets to
+// a temp file instead of letting the in-memory ring grow without bound.
+type spillPolicy struct {
+	highWater int
+	lowWater  int
+	workers   int
+}
+
+// spillState is the runtime state behind SetSpillPolicy: a temp file
+// holding spilled packets as a sequence of independent gzip members (one
+// per packet, compressed by a worker pool in parallel, pgzip-style) plus a
+// reader that streams them back out member-by-member in the order they
+// were written.
+type spillState struct {
+	writeFile *os.File
+	readFile  *os.File
+	reader    *bufio.Reader
+
+	sem chan struct{}
+
+	packets int   // spilled packets not yet read back
+	bytes   int64 // framed byte size of those packets (SpilledBytes)
+}
+
+// SetSpillPolicy enables disk spilling: once the in-memory ring grows past
+// highWater bytes, Write moves the oldest packets out to a temp file in
+// dir (compressed by a pool of workers workers, pgzip-style) until the
+// ring is back at or below lowWater. Spilling and the corresponding
+// streaming decompression on Read are both transparent to callers: Count,
+// Size and the FIFO order Read delivers in are unaffected. It must be
+// called before the buffer is used and must not be called more than once.
+func (b *Buffer) SetSpillPolicy(highWater, lowWater int, dir string, workers int) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	f, err := os.CreateTemp(dir, "controller-buffer-spill-*.gz")
+	if err != nil {
+		return fmt.Errorf("spill policy: %w", err)
+	}
+
+	rf, err := os.Open(f.Name())
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("spill policy: %w", err)
+	}
+
+	b.mutex.Lock()
+	b.policy = spillPolicy{highWater: highWater, lowWater: lowWater, workers: workers}
+	b.spill = &spillState{
+		writeFile: f,
+		readFile:  rf,
+		sem:       make(chan struct{}, workers),
+	}
+	b.mutex.Unlock()
+
+	return nil
+}
+
+// SpilledBytes returns the framed byte size of packets currently sitting
+// in the spill file, not yet read back. It is always 0 for a Buffer
+// without a spill policy.
+func (b *Buffer) SpilledBytes() int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.spill == nil {
+		return 0
+	}
+	return b.spill.bytes
+}
+
+// totalCountLocked returns the number of packets held by the buffer,
+// in memory and spilled combined.
+func (b *Buffer) totalCountLocked() int {
+	if b.spill == nil {
+		return b.count
+	}
+	return b.count + b.spill.packets
+}
+
+// totalSizeLocked returns the framed byte size of the packets held by the
+// buffer, in memory and spilled combined.
+func (b *Buffer) totalSizeLocked() int {
+	size := b.buf.size
+	if b.spill != nil {
+		size += int(b.spill.bytes)
+	}
+	return size
+}
+
+// spillOldestLocked moves whole packets from the head of the in-memory
+// ring to the spill file until the ring is back at or below the
+// low-water mark, compressing them in parallel across b.policy.workers
+// workers. It must be called with b.mutex held, and keeps it held for the
+// whole operation: a packet dequeued from the ring must become visible in
+// s.packets/s.bytes before the lock is released, or a concurrent Read
+// could see it in neither place and skip ahead to a newer packet.
+func (b *Buffer) spillOldestLocked() {
+	if b.spill == nil || b.policy.highWater <= 0 || b.buf.size <= b.policy.highWater {
+		return
+	}
+
+	var batch [][]byte
+	for b.buf.size > b.policy.lowWater && b.count > 0 {
+		batch = append(batch, b.dequeueRawLocked())
+	}
+	if len(batch) > 0 {
+		b.spillBatchLocked(batch)
+	}
+}
+
+// dequeueRawLocked removes and returns the oldest packet's raw framed
+// bytes (length header, payload, metadata trailer) from the in-memory
+// ring. It must be called with b.mutex held and b.count > 0.
+func (b *Buffer) dequeueRawLocked() []byte {
+	var header [2]byte
+	b.buf.read(header[:])
+	count := int((uint16(header[0]) << 8) | uint16(header[1]))
+
+	raw := make([]byte, 2+count+metadataSize)
+	copy(raw, header[:])
+	b.buf.read(raw[2 : 2+count])
+	b.buf.read(raw[2+count:])
+
+	b.count--
+
+	return raw
+}
+
+// spillBatchLocked compresses each packet in batch as an independent gzip
+// member, in parallel across b.policy.workers workers, then appends the
+// members to the spill file in their original order and records them in
+// s.packets/s.bytes. It must be called with b.mutex held; the lock stays
+// held across the wg.Wait() below so the packets in batch — already
+// removed from the in-memory ring by the caller — become visible in the
+// spill bookkeeping atomically, with no window where a concurrent Read
+// could find them in neither place. Worker goroutines only touch their
+// own slot of compressed, so holding the lock across Wait introduces no
+// risk of them deadlocking on it.
+func (b *Buffer) spillBatchLocked(batch [][]byte) {
+	s := b.spill
+	compressed := make([][]byte, len(batch))
+
+	var wg sync.WaitGroup
+	for i, raw := range batch {
+		i, raw := i, raw
+		wg.Add(1)
+		s.sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-s.sem }()
+
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			_, _ = zw.Write(raw)
+			_ = zw.Close()
+			compressed[i] = buf.Bytes()
+		}()
+	}
+	wg.Wait()
+
+	for i, c := range compressed {
+		_, _ = s.writeFile.Write(c)
+		s.packets++
+		s.bytes += int64(len(batch[i]))
+	}
+	_ = s.writeFile.Sync()
+}
+
+// readSpilledLocked decodes and returns the oldest spilled packet,
+// streaming it off the spill file's gzip member sequence one member at a
+// time. Each member gets its own single-shot gzip.Reader rather than one
+// reused across members: a gzip.Reader in its default multistream mode
+// latches io.EOF for good the moment it probes for a next member that
+// hasn't been written yet, which would wedge every later read once the
+// spill file is drained and later refilled. It must be called with
+// b.mutex held and b.spill.packets > 0.
+func (b *Buffer) readSpilledLocked(packet []byte) (int, Metadata, error) {
+	s := b.spill
+
+	if s.reader == nil {
+		s.reader = bufio.NewReader(s.readFile)
+	}
+
+	gz, err := gzip.NewReader(s.reader)
+	if err != nil {
+		return 0, Metadata{}, err
+	}
+	gz.Multistream(false)
+
+	var header [2]byte
+	if _, err := io.ReadFull(gz, header[:]); err != nil {
+		return 0, Metadata{}, err
+	}
+	count := int((uint16(header[0]) << 8) | uint16(header[1]))
+
+	if len(packet) < count {
+		return 0, Metadata{}, fmt.Errorf("short buffer, at least %d is needed", count)
+	}
+	if _, err := io.ReadFull(gz, packet[:count]); err != nil {
+		return 0, Metadata{}, err
+	}
+
+	var trailer [metadataSize]byte
+	if _, err := io.ReadFull(gz, trailer[:]); err != nil {
+		return 0, Metadata{}, err
+	}
+
+	s.packets--
+	s.bytes -= int64(2 + count + metadataSize)
+
+	// The buffer was closed while packets were still spilled: now that
+	// the last of them has been read back, nothing will ever append to
+	// the spill file again, so it's safe to clean it up.
+	if s.packets == 0 && b.closed {
+		b.closeSpill()
+	}
+
+	return count, decodeMetadataTrailer(trailer), nil
+}
+
+// closeSpill releases the spill file's handles and removes it from disk.
+// It must be called with b.mutex held.
+func (b *Buffer) closeSpill() {
+	s := b.spill
+	if s == nil {
+		return
+	}
+
+	_ = s.readFile.Close()
+	path := s.writeFile.Name()
+	_ = s.writeFile.Close()
+	_ = os.Remove(path)
+}
@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferSpillRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	buffer := NewBuffer()
+	err := buffer.SetSpillPolicy(40, 10, t.TempDir(), 4)
+	assert.NoError(err)
+
+	const n = 50
+	for i := 0; i < n; i++ {
+		_, err := buffer.Write([]byte{byte(i)})
+		assert.NoError(err)
+	}
+	assert.Greater(buffer.SpilledBytes(), int64(0))
+
+	packet := make([]byte, 1)
+	for i := 0; i < n; i++ {
+		nr, err := buffer.Read(packet)
+		assert.NoError(err)
+		assert.Equal(1, nr)
+		assert.Equal(byte(i), packet[0])
+	}
+	assert.Equal(int64(0), buffer.SpilledBytes())
+}
+
+func TestBufferSpillMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	buffer := NewBuffer()
+	err := buffer.SetSpillPolicy(20, 5, t.TempDir(), 2)
+	assert.NoError(err)
+
+	ts := time.Now()
+	_, err = buffer.WriteWithMetadata([]byte{1, 2, 3}, Metadata{Timestamp: ts})
+	assert.NoError(err)
+
+	// Push enough plain packets to force the first one to spill.
+	for i := 0; i < 10; i++ {
+		_, err := buffer.Write([]byte{byte(i), byte(i), byte(i)})
+		assert.NoError(err)
+	}
+	assert.Greater(buffer.SpilledBytes(), int64(0))
+
+	packet := make([]byte, 3)
+	n, md, err := buffer.ReadWithMetadata(packet)
+	assert.NoError(err)
+	assert.Equal(3, n)
+	assert.Equal([]byte{1, 2, 3}, packet[:n])
+	assert.True(ts.Equal(md.Timestamp))
+
+	// The rest were written with the zero Metadata and must still
+	// round-trip as the zero time once they come back off the spill file.
+	for i := 0; i < 10; i++ {
+		_, md, err := buffer.ReadWithMetadata(packet)
+		assert.NoError(err)
+		assert.True(md.Timestamp.IsZero())
+	}
+}
+
+// TestBufferSpillConcurrentReadWrite writes and reads concurrently across
+// the high-water mark, so packets are actively being spilled while a
+// reader is draining them. Every packet must still come back in the order
+// it was written.
+func TestBufferSpillConcurrentReadWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	buffer := NewBuffer()
+	err := buffer.SetSpillPolicy(200, 50, t.TempDir(), 4)
+	assert.NoError(err)
+
+	const n = 500
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			if _, err := buffer.Write([]byte{byte(i), byte(i >> 8)}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	packet := make([]byte, 2)
+	for i := 0; i < n; i++ {
+		nr, err := buffer.Read(packet)
+		assert.NoError(err)
+		assert.Equal(2, nr)
+		assert.Equal(byte(i), packet[0], "FIFO violation at read %d", i)
+		assert.Equal(byte(i>>8), packet[1])
+	}
+
+	assert.NoError(<-done)
+}
+
+// TestBufferSpillCloseDrains confirms that packets already spilled when
+// Close is called are still delivered, with io.EOF only once they're
+// exhausted, rather than a file-handle error from the spill file being
+// removed out from under the read.
+func TestBufferSpillCloseDrains(t *testing.T) {
+	assert := assert.New(t)
+
+	buffer := NewBuffer()
+	err := buffer.SetSpillPolicy(10, 2, t.TempDir(), 2)
+	assert.NoError(err)
+
+	const n = 20
+	for i := 0; i < n; i++ {
+		_, err := buffer.Write([]byte{byte(i)})
+		assert.NoError(err)
+	}
+	assert.Greater(buffer.SpilledBytes(), int64(0))
+
+	assert.NoError(buffer.Close())
+
+	packet := make([]byte, 1)
+	for i := 0; i < n; i++ {
+		nr, err := buffer.Read(packet)
+		assert.NoError(err)
+		assert.Equal(1, nr)
+		assert.Equal(byte(i), packet[0])
+	}
+
+	_, err = buffer.Read(packet)
+	assert.Equal(io.EOF, err)
+}
@@ -0,0 +1,67 @@
+package list
+
+import "sync"
+
+// Size classes for BufferPool: powers of two from 64 bytes up to 64KB,
+// the largest packet Buffer accepts, following the go-buffer-pool
+// approach of bucketing pooled allocations by size class.
+const (
+	bufferPoolMinShift = 6  // smallest bucket: 64 bytes
+	bufferPoolMaxShift = 16 // largest bucket: 65536 bytes
+	bufferPoolBuckets  = bufferPoolMaxShift - bufferPoolMinShift + 1
+)
+
+// BufferPool hands out and recycles the byte slices used by a Buffer's
+// zero-copy WriteNoCopy/Get/Release path. The zero value is not usable;
+// create one with NewBufferPool. A single BufferPool can be shared
+// across multiple Buffers via NewBufferWithPool.
+type BufferPool struct {
+	buckets [bufferPoolBuckets]sync.Pool
+}
+
+// NewBufferPool creates an empty BufferPool.
+func NewBufferPool() *BufferPool {
+	pool := &BufferPool{}
+	for i := range pool.buckets {
+		size := 1 << (bufferPoolMinShift + i)
+		pool.buckets[i].New = func() interface{} {
+			return make([]byte, size)
+		}
+	}
+	return pool
+}
+
+// bucketFor returns the index of the smallest size class that can hold
+// size bytes, clamped to the largest bucket.
+func bucketFor(size int) int {
+	shift := bufferPoolMinShift
+	for shift < bufferPoolMaxShift && 1<<shift < size {
+		shift++
+	}
+	return shift - bufferPoolMinShift
+}
+
+// Get returns a slice of length size drawn from the smallest size class
+// that fits it.
+func (p *BufferPool) Get(size int) []byte {
+	buf := p.buckets[bucketFor(size)].Get().([]byte)
+	return buf[:size]
+}
+
+// Put returns buf to the bucket matching its capacity, for reuse by a
+// future Get. buf must have been obtained from this pool, directly via
+// Get or indirectly via Buffer.Get after a pool-backed WriteNoCopy. A buf
+// whose capacity isn't exactly one of the pool's size classes (for
+// example a slice WriteNoCopy accepted from a caller that didn't draw it
+// from this pool) is discarded rather than filed into the wrong bucket,
+// where a later Get would slice past its true capacity.
+func (p *BufferPool) Put(buf []byte) {
+	shift := bufferPoolMinShift
+	for shift <= bufferPoolMaxShift && 1<<shift != cap(buf) {
+		shift++
+	}
+	if shift > bufferPoolMaxShift {
+		return
+	}
+	p.buckets[shift-bufferPoolMinShift].Put(buf[:cap(buf)]) //nolint:staticcheck
+}
@@ -2,7 +2,9 @@ package list
 
 import (
 	"container/list"
+	"context"
 	"errors"
+	"io"
 	"sync"
 	"time"
 )
@@ -13,10 +15,28 @@ type Buffer struct {
 
 	pktqueue          *list.List
 	count, buffersize int
+
+	notify chan struct{}
+	closed bool
+
+	limitCount int
+	limitSize  int
+
+	readDeadline time.Time
+
+	pool *BufferPool
+
+	packetType    BufferPacketType
+	reorderWindow int
+	nextSeq       uint16
+	seqValid      bool
+	pending       map[uint16]*Packet
+	stats         Stats
 }
 
 type Packet struct {
-	payload []byte
+	payload  []byte
+	metadata Metadata
 }
 
 type Metadata struct {
@@ -26,61 +46,284 @@ type Metadata struct {
 var (
 	// ErrPacketTooBig is returned when the incoming packet is larger than 65536 bytes.
 	ErrPacketTooBig = errors.New("packet too big")
+
+	// ErrFull is returned by Write when LimitCount or LimitSize would be exceeded.
+	ErrFull = errors.New("buffer is full")
+
+	// ErrBufferClosed is returned by Write once the buffer has been closed.
+	ErrBufferClosed = errors.New("buffer is closed")
 )
 
-// NewBuffer creates a new packet buffer.
+// NewBuffer creates a new packet buffer with its own BufferPool.
 func NewBuffer() *Buffer {
+	return NewBufferWithPool(NewBufferPool())
+}
+
+// NewBufferWithPool creates a new packet buffer backed by pool, so that
+// slices released by this Buffer's Release can be reused by any other
+// Buffer sharing the same pool.
+func NewBufferWithPool(pool *BufferPool) *Buffer {
+	return newBuffer(pool, RTCPBufferPacket)
+}
+
+// NewBufferWithType creates a new packet buffer aware of the kind of
+// packets it holds. When t is RTPBufferPacket, Write parses the RTP
+// sequence number of each packet and Read delivers packets in ascending
+// sequence order, buffering out-of-order arrivals within a reordering
+// window (see SetReorderWindow). RTCPBufferPacket behaves like NewBuffer:
+// a plain FIFO with no reordering.
+func NewBufferWithType(t BufferPacketType) *Buffer {
+	return newBuffer(NewBufferPool(), t)
+}
+
+func newBuffer(pool *BufferPool, t BufferPacketType) *Buffer {
 	return &Buffer{
-		pktqueue: list.New(),
+		pktqueue:      list.New(),
+		notify:        make(chan struct{}),
+		pool:          pool,
+		packetType:    t,
+		reorderWindow: defaultReorderWindow,
+		pending:       make(map[uint16]*Packet),
 	}
 }
 
+// wake unblocks every reader currently waiting in Read by closing the
+// notify channel, then replaces it so that future waiters block again.
+func (b *Buffer) wake() {
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// SetLimitCount sets the maximum number of packets the buffer will hold.
+// Write returns ErrFull once the limit would be exceeded. A limit of 0
+// (the default) means unlimited.
+func (b *Buffer) SetLimitCount(limit int) {
+	b.mutex.Lock()
+	b.limitCount = limit
+	b.mutex.Unlock()
+}
+
+// SetLimitSize sets the maximum total payload byte size the buffer will
+// hold. Write returns ErrFull once the limit would be exceeded. A limit
+// of 0 (the default) means unlimited.
+func (b *Buffer) SetLimitSize(limit int) {
+	b.mutex.Lock()
+	b.limitSize = limit
+	b.mutex.Unlock()
+}
+
+// SetReadDeadline sets the deadline for future calls to Read. A zero
+// value removes the deadline, causing Read to block indefinitely until a
+// packet arrives or the buffer is closed.
+func (b *Buffer) SetReadDeadline(t time.Time) {
+	b.mutex.Lock()
+	b.readDeadline = t
+	b.mutex.Unlock()
+}
+
+// Close closes the buffer, unblocking all pending and future readers with
+// io.EOF once the buffer has drained. It is safe to call Close more than
+// once.
+func (b *Buffer) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	b.wake()
+
+	return nil
+}
+
 // Write appends a copy of the packet data to the buffer.
-// Returns ErrFull if the buffer is full
+// Returns ErrFull if LimitCount or LimitSize is exceeded
 // Returns ErrPacketTooBig if the packet size exceeds 65536 bytes
+// Returns ErrBufferClosed if the buffer has been closed
 func (b *Buffer) Write(packet []byte) (int, error) {
+	return b.WriteWithMetadata(packet, Metadata{})
+}
+
+// WriteWithMetadata appends a copy of the packet data to the buffer along
+// with its metadata, which ReadWithMetadata later returns alongside the
+// packet. Errors are identical to Write.
+func (b *Buffer) WriteWithMetadata(packet []byte, md Metadata) (int, error) {
 	if len(packet) >= 0x10000 {
 		return 0, ErrPacketTooBig
 	}
 
 	b.mutex.Lock()
 
-	pkt := &Packet{
-		payload: packet,
+	if b.closed {
+		b.mutex.Unlock()
+		return 0, ErrBufferClosed
 	}
 
-	b.pktqueue.PushBack(pkt)
-	pktlen := len(packet)
-	b.buffersize += pktlen
-	b.count++
+	if b.limitCount > 0 && b.count+1 > b.limitCount {
+		b.mutex.Unlock()
+		return 0, ErrFull
+	}
+	if b.limitSize > 0 && b.buffersize+len(packet) > b.limitSize {
+		b.mutex.Unlock()
+		return 0, ErrFull
+	}
+
+	payload := make([]byte, len(packet))
+	copy(payload, packet)
+
+	n, err := b.writeLocked(&Packet{payload: payload, metadata: md})
+	b.mutex.Unlock()
+
+	return n, err
+}
+
+// WriteNoCopy appends packet to the buffer without copying it, taking
+// ownership of the slice: the caller must not read or write packet again
+// until it comes back out via Read, ReadWithMetadata, or Get. Errors are
+// identical to Write.
+func (b *Buffer) WriteNoCopy(packet []byte) (int, error) {
+	if len(packet) >= 0x10000 {
+		return 0, ErrPacketTooBig
+	}
+
+	b.mutex.Lock()
+
+	if b.closed {
+		b.mutex.Unlock()
+		return 0, ErrBufferClosed
+	}
+
+	if b.limitCount > 0 && b.count+1 > b.limitCount {
+		b.mutex.Unlock()
+		return 0, ErrFull
+	}
+	if b.limitSize > 0 && b.buffersize+len(packet) > b.limitSize {
+		b.mutex.Unlock()
+		return 0, ErrFull
+	}
+
+	n, err := b.writeLocked(&Packet{payload: packet})
 	b.mutex.Unlock()
 
-	return pktlen, nil
+	return n, err
+}
+
+// writeLocked queues pkt for delivery, holding it back for in-order
+// delivery when the buffer was constructed with NewBufferWithType(RTPBufferPacket).
+func (b *Buffer) writeLocked(pkt *Packet) (int, error) {
+	if b.packetType == RTPBufferPacket {
+		seq, _, err := parseRTPHeader(pkt.payload)
+		if err != nil {
+			return 0, err
+		}
+		b.stats.Received++
+		b.writeRTPLocked(seq, pkt)
+		return len(pkt.payload), nil
+	}
+
+	b.enqueueLocked(pkt)
+
+	return len(pkt.payload), nil
+}
+
+// enqueueLocked makes pkt immediately available to readers.
+func (b *Buffer) enqueueLocked(pkt *Packet) {
+	b.pktqueue.PushBack(pkt)
+	b.buffersize += len(pkt.payload)
+
+	wasEmpty := b.count == 0
+	b.count++
+	if wasEmpty {
+		b.wake()
+	}
 }
 
 // Read populates the given byte slice, returning the number of bytes read.
-// If return 0, the buffer is empty
+// If the buffer is empty, Read blocks until a packet is written, the
+// buffer is closed (returning io.EOF), or the read deadline set by
+// SetReadDeadline expires (returning context.DeadlineExceeded).
 // Returns io.ErrShortBuffer is the given packet is too small to copy
 func (b *Buffer) Read(packet []byte) (n int, err error) {
-	b.mutex.Lock()
+	n, _, err = b.ReadWithMetadata(packet)
+	return n, err
+}
 
-	if b.pktqueue.Len() > 0 {
-		pktpt := b.pktqueue.Front()
-		pkt := pktpt.Value.(*Packet)
-		copy(packet, pkt.payload)
+// ReadWithMetadata behaves like Read, but additionally returns the
+// Metadata stored alongside the packet by WriteWithMetadata (or the zero
+// Metadata if the packet was stored with Write).
+func (b *Buffer) ReadWithMetadata(packet []byte) (n int, md Metadata, err error) {
+	pkt, err := b.popFront()
+	if err != nil {
+		return 0, Metadata{}, err
+	}
 
-		b.count--
-		pktlen := len(pkt.payload)
-		b.buffersize -= pktlen
+	copy(packet, pkt.payload)
 
-		b.pktqueue.Remove(pktpt)
+	return len(pkt.payload), pkt.metadata, nil
+}
 
-		b.mutex.Unlock()
-		return pktlen, nil
+// Get dequeues the head packet and returns its payload slice directly,
+// without copying it into a caller-supplied buffer. Errors are identical
+// to Read. Once done with the slice, return it to the buffer's pool with
+// Release.
+func (b *Buffer) Get() ([]byte, error) {
+	pkt, err := b.popFront()
+	if err != nil {
+		return nil, err
 	}
 
-	b.mutex.Unlock()
-	return 0, nil
+	return pkt.payload, nil
+}
+
+// Release returns buf, previously obtained from Get, to the buffer's
+// BufferPool for reuse by a future BufferPool.Get.
+func (b *Buffer) Release(buf []byte) {
+	b.pool.Put(buf)
+}
+
+// popFront dequeues and returns the head packet, blocking until one is
+// available, the buffer is closed (io.EOF), or the read deadline set by
+// SetReadDeadline expires (context.DeadlineExceeded).
+func (b *Buffer) popFront() (*Packet, error) {
+	for {
+		b.mutex.Lock()
+
+		if b.pktqueue.Len() > 0 {
+			pktpt := b.pktqueue.Front()
+			pkt := pktpt.Value.(*Packet)
+
+			b.count--
+			b.buffersize -= len(pkt.payload)
+
+			b.pktqueue.Remove(pktpt)
+
+			b.mutex.Unlock()
+			return pkt, nil
+		}
+
+		if b.closed {
+			b.mutex.Unlock()
+			return nil, io.EOF
+		}
+
+		notify := b.notify
+		deadline := b.readDeadline
+		b.mutex.Unlock()
+
+		if deadline.IsZero() {
+			<-notify
+			continue
+		}
+
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-notify:
+			timer.Stop()
+		case <-timer.C:
+			return nil, context.DeadlineExceeded
+		}
+	}
 }
 
 // Count returns the number of packets in the buffer.
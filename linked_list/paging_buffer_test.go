@@ -1,7 +1,10 @@
 package list
 
 import (
+	"context"
+	"io"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -51,12 +54,116 @@ func TestBuffer(t *testing.T) {
 	assert.Equal(3, n)
 	assert.Equal([]byte{5, 6, 7}, packet[:n])
 
-	// Test Read an empty buffer
+	// Test Read an empty buffer: with a read deadline set, Read returns
+	// context.DeadlineExceeded once it expires; after Close, it returns io.EOF.
+	buffer.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
 	n, err = buffer.Read(packet)
+	assert.Equal(context.DeadlineExceeded, err)
+	assert.Equal(0, n)
+
+	err = buffer.Close()
 	assert.NoError(err)
+
+	n, err = buffer.Read(packet)
+	assert.Equal(io.EOF, err)
 	assert.Equal(0, n)
 }
 
+func TestBufferMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	buffer := NewBuffer()
+	packet := make([]byte, 4)
+
+	ts := time.Now()
+	n, err := buffer.WriteWithMetadata([]byte{1, 2, 3}, Metadata{Timestamp: ts})
+	assert.NoError(err)
+	assert.Equal(3, n)
+
+	// ReadWithMetadata returns the packet together with its timestamp.
+	n, md, err := buffer.ReadWithMetadata(packet)
+	assert.NoError(err)
+	assert.Equal(3, n)
+	assert.Equal([]byte{1, 2, 3}, packet[:n])
+	assert.True(ts.Equal(md.Timestamp))
+
+	// Packets written with Write carry the zero Metadata.
+	_, err = buffer.Write([]byte{4, 5})
+	assert.NoError(err)
+	_, md, err = buffer.ReadWithMetadata(packet)
+	assert.NoError(err)
+	assert.True(md.Timestamp.IsZero())
+}
+
+func TestBufferNoCopy(t *testing.T) {
+	assert := assert.New(t)
+
+	pool := NewBufferPool()
+	buffer := NewBufferWithPool(pool)
+
+	buf := pool.Get(3)
+	copy(buf, []byte{1, 2, 3})
+
+	n, err := buffer.WriteNoCopy(buf)
+	assert.NoError(err)
+	assert.Equal(3, n)
+
+	got, err := buffer.Get()
+	assert.NoError(err)
+	assert.Equal([]byte{1, 2, 3}, got)
+
+	buffer.Release(got)
+}
+
+// rtpPacket builds a minimal RTP packet carrying seq as its sequence
+// number, with a single payload byte for identification.
+func rtpPacket(seq uint16, payload byte) []byte {
+	return []byte{
+		0x80, 0x60,
+		byte(seq >> 8), byte(seq),
+		0, 0, 0, 0, // timestamp
+		0, 0, 0, 0, // SSRC
+		payload,
+	}
+}
+
+func TestBufferRTPReorder(t *testing.T) {
+	assert := assert.New(t)
+
+	buffer := NewBufferWithType(RTPBufferPacket)
+	packet := make([]byte, rtpHeaderSize+1)
+
+	// Packets 1 and 3 arrive before packet 2: 3 should be held back until
+	// 2 fills the gap.
+	_, err := buffer.Write(rtpPacket(1, 'a'))
+	assert.NoError(err)
+	_, err = buffer.Write(rtpPacket(3, 'c'))
+	assert.NoError(err)
+	_, err = buffer.Write(rtpPacket(2, 'b'))
+	assert.NoError(err)
+
+	for _, want := range []byte{'a', 'b', 'c'} {
+		n, err := buffer.Read(packet)
+		assert.NoError(err)
+		assert.Equal(want, packet[n-1])
+	}
+
+	stats := buffer.Stats()
+	assert.Equal(3, stats.Received)
+	assert.Equal(1, stats.Reordered)
+	assert.Equal(0, stats.Dropped)
+	assert.Equal(0, stats.DuplicatesDropped)
+
+	// A duplicate of an already-delivered sequence number is dropped.
+	_, err = buffer.Write(rtpPacket(2, 'b'))
+	assert.NoError(err)
+	assert.Equal(1, buffer.Stats().DuplicatesDropped)
+
+	// Too short to contain an RTP header.
+	_, err = buffer.Write([]byte{0x80, 0x60})
+	assert.Equal(ErrInvalidRTPHeader, err)
+}
+
 func benchmarkBufferWR(b *testing.B, size int64, write bool, grow int) { // nolint:unparam
 	buffer := NewBuffer()
 	packet := make([]byte, size)
@@ -124,3 +231,60 @@ func BenchmarkBufferWWR140(b *testing.B) {
 func BenchmarkBufferWWR1400(b *testing.B) {
 	benchmarkBufferWR(b, 1400, true, 10*1024*1024)
 }
+
+// benchmarkBufferNoCopyWR compares the zero-copy WriteNoCopy/Get path
+// against the copying Write/Read path for a given packet size.
+func benchmarkBufferNoCopyWR(b *testing.B, size int64, noCopy bool) {
+	buffer := NewBuffer()
+	pool := NewBufferPool()
+	packet := make([]byte, size)
+
+	b.SetBytes(size)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if noCopy {
+			buf := pool.Get(int(size))
+			copy(buf, packet)
+			if _, err := buffer.WriteNoCopy(buf); err != nil {
+				b.Fatalf("WriteNoCopy: %v", err)
+			}
+			got, err := buffer.Get()
+			if err != nil {
+				b.Fatalf("Get: %v", err)
+			}
+			pool.Put(got)
+		} else {
+			if _, err := buffer.Write(packet); err != nil {
+				b.Fatalf("Write: %v", err)
+			}
+			if _, err := buffer.Read(packet); err != nil {
+				b.Fatalf("Read: %v", err)
+			}
+		}
+	}
+}
+
+func BenchmarkBufferCopyWR14(b *testing.B) {
+	benchmarkBufferNoCopyWR(b, 14, false)
+}
+
+func BenchmarkBufferCopyWR140(b *testing.B) {
+	benchmarkBufferNoCopyWR(b, 140, false)
+}
+
+func BenchmarkBufferCopyWR1400(b *testing.B) {
+	benchmarkBufferNoCopyWR(b, 1400, false)
+}
+
+func BenchmarkBufferNoCopyWR14(b *testing.B) {
+	benchmarkBufferNoCopyWR(b, 14, true)
+}
+
+func BenchmarkBufferNoCopyWR140(b *testing.B) {
+	benchmarkBufferNoCopyWR(b, 140, true)
+}
+
+func BenchmarkBufferNoCopyWR1400(b *testing.B) {
+	benchmarkBufferNoCopyWR(b, 1400, true)
+}
@@ -0,0 +1,142 @@
+package list
+
+import "errors"
+
+// BufferPacketType tells a Buffer what kind of packets it holds, so it
+// knows whether to reorder them.
+type BufferPacketType int
+
+const (
+	// RTCPBufferPacket is the default: packets are delivered in the order
+	// they were written, as a plain FIFO.
+	RTCPBufferPacket BufferPacketType = iota
+
+	// RTPBufferPacket tells the Buffer to parse the RTP sequence number
+	// of every packet and deliver packets in ascending sequence order,
+	// buffering out-of-order arrivals within the reordering window.
+	RTPBufferPacket
+)
+
+// defaultReorderWindow is the number of in-flight sequence numbers an
+// RTPBufferPacket Buffer will hold a packet for before giving up on the
+// packets that would have preceded it.
+const defaultReorderWindow = 32
+
+// rtpHeaderSize is the size of a minimal RTP header (no extensions or
+// CSRC identifiers): version/flags, payload type, sequence number,
+// timestamp and SSRC.
+const rtpHeaderSize = 12
+
+// ErrInvalidRTPHeader is returned by Write when the buffer was created
+// with NewBufferWithType(RTPBufferPacket) and the packet is too short to
+// contain an RTP header.
+var ErrInvalidRTPHeader = errors.New("rtp header too short")
+
+// Stats reports how an RTPBufferPacket Buffer has handled the packets
+// written to it.
+type Stats struct {
+	// Received is the number of packets accepted by Write.
+	Received int
+	// Reordered is the number of packets that arrived out of sequence
+	// order and were held until the buffer could deliver them in order.
+	Reordered int
+	// Dropped is the number of buffered out-of-order packets discarded
+	// because the reordering window slid past them before the gap
+	// preceding them was filled.
+	Dropped int
+	// DuplicatesDropped is the number of packets discarded because their
+	// sequence number was at or behind one already delivered, or matched
+	// a packet already buffered for reordering.
+	DuplicatesDropped int
+}
+
+// Stats returns a snapshot of the buffer's RTP reordering counters. It is
+// always zero for a Buffer not constructed with NewBufferWithType(RTPBufferPacket).
+func (b *Buffer) Stats() Stats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.stats
+}
+
+// SetReorderWindow sets the number of in-flight sequence numbers an
+// RTPBufferPacket Buffer holds a packet for before giving up on the
+// packets that would have preceded it. It has no effect on an
+// RTCPBufferPacket Buffer.
+func (b *Buffer) SetReorderWindow(n int) {
+	b.mutex.Lock()
+	b.reorderWindow = n
+	b.mutex.Unlock()
+}
+
+// parseRTPHeader extracts the 16-bit sequence number and 32-bit
+// timestamp from an RTP packet header.
+func parseRTPHeader(packet []byte) (seq uint16, timestamp uint32, err error) {
+	if len(packet) < rtpHeaderSize {
+		return 0, 0, ErrInvalidRTPHeader
+	}
+
+	seq = uint16(packet[2])<<8 | uint16(packet[3])
+	timestamp = uint32(packet[4])<<24 | uint32(packet[5])<<16 | uint32(packet[6])<<8 | uint32(packet[7])
+
+	return seq, timestamp, nil
+}
+
+// writeRTPLocked places pkt, whose RTP sequence number is seq, either
+// straight onto the delivery queue, into the reordering window, or drops
+// it, depending on how seq relates to the next sequence number expected.
+func (b *Buffer) writeRTPLocked(seq uint16, pkt *Packet) {
+	if !b.seqValid {
+		b.seqValid = true
+		b.nextSeq = seq
+	}
+
+	// fwd is how far ahead of nextSeq seq is, accounting for 16-bit
+	// wraparound: negative means seq is at or behind a sequence number
+	// already delivered.
+	fwd := int16(seq - b.nextSeq)
+
+	switch {
+	case fwd < 0:
+		b.stats.DuplicatesDropped++
+
+	case fwd == 0:
+		b.enqueueLocked(pkt)
+		b.nextSeq++
+		b.drainPendingLocked()
+
+	case int(fwd) < b.reorderWindow:
+		if _, exists := b.pending[seq]; exists {
+			b.stats.DuplicatesDropped++
+			return
+		}
+		b.pending[seq] = pkt
+		b.stats.Reordered++
+
+	default:
+		// The gap between nextSeq and seq is wider than the reordering
+		// window, so whatever we were waiting for is gone. Slide the
+		// window up to seq, dropping anything left behind in it.
+		for k := range b.pending {
+			delete(b.pending, k)
+			b.stats.Dropped++
+		}
+		b.nextSeq = seq
+		b.enqueueLocked(pkt)
+		b.nextSeq++
+		b.drainPendingLocked()
+	}
+}
+
+// drainPendingLocked moves every contiguous run of packets starting at
+// nextSeq from the reordering window onto the delivery queue.
+func (b *Buffer) drainPendingLocked() {
+	for {
+		pkt, ok := b.pending[b.nextSeq]
+		if !ok {
+			return
+		}
+		delete(b.pending, b.nextSeq)
+		b.enqueueLocked(pkt)
+		b.nextSeq++
+	}
+}